@@ -0,0 +1,54 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// BasicAuth holds HTTP basic-auth credentials for the "http" prober's
+// client. Password follows this repo's inline-or-file convention (see
+// internal/pathorcontent): it may be given directly or as a path in the
+// sibling password_file field, re-read on every probe so a rotated
+// credential takes effect without restarting the exporter.
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// Authorization holds a bearer-style Authorization header for the "http"
+// prober's client. Credentials follows the same inline-or-file convention
+// as BasicAuth.Password.
+type Authorization struct {
+	Type            string `yaml:"type,omitempty"`
+	Credentials     string `yaml:"credentials,omitempty"`
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+}
+
+// TLSConfig describes the client certificate used when probing an HTTPS
+// target, following the same inline-or-file convention as
+// internal/webconfig.TLSConfig on the server side.
+type TLSConfig struct {
+	Cert               string `yaml:"cert,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	Key                string `yaml:"key,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	CAFile             string `yaml:"ca_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// HTTPClientConfig configures the HTTP client used by the "http" prober.
+type HTTPClientConfig struct {
+	BasicAuth     *BasicAuth     `yaml:"basic_auth,omitempty"`
+	Authorization *Authorization `yaml:"authorization,omitempty"`
+	TLSConfig     TLSConfig      `yaml:"tls_config,omitempty"`
+}
@@ -0,0 +1,35 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "time"
+
+// HTTPProbe holds the "http" prober's settings for a module.
+type HTTPProbe struct {
+	HTTPClientConfig HTTPClientConfig `yaml:",inline"`
+}
+
+// Module is a single entry of the blackbox.yml "modules" map: which prober
+// to run against a target, and that prober's settings.
+//
+// NOTE: this is a minimal surface covering only what chunk0-3 needs
+// (HTTPClientConfig's file-sourced secrets, wired through the "http"
+// prober). The tcp/icmp/dns prober settings, and the SafeConfig/Config/
+// RecoverConfig machinery main.go also imports from this package, aren't
+// present in this checkout and are out of scope for this fix.
+type Module struct {
+	Prober  string        `yaml:"prober,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	HTTP    HTTPProbe     `yaml:"http,omitempty"`
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pathorcontent resolves secret fields that may be given either
+// inline or via a file, following the `<field>`/`<field>_file` sibling-key
+// convention already used for TLS certs (e.g. prometheus/common/config's
+// TLSConfig, Thanos's extflag.PathOrContent): a YAML struct carries both a
+// plain string field and a "_file" string field, and at most one is set.
+package pathorcontent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Get resolves the effective value of an inline/file field pair, e.g.
+//
+//	type BasicAuth struct {
+//		Password     string `yaml:"password,omitempty"`
+//		PasswordFile string `yaml:"password_file,omitempty"`
+//	}
+//	...
+//	pass, err := pathorcontent.Get(auth.Password, auth.PasswordFile)
+//
+// file is read fresh on every call rather than once at config-load time, so
+// a mounted Kubernetes secret can rotate without a config reload.
+func Get(inline, file string) (string, error) {
+	if inline != "" && file != "" {
+		return "", fmt.Errorf("at most one of the inline value and its _file sibling may be set")
+	}
+	if file == "" {
+		return inline, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %s", file, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
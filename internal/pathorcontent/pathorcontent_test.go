@@ -0,0 +1,97 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathorcontent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetInline(t *testing.T) {
+	got, err := Get("s3cr3t", "")
+	if err != nil {
+		t.Fatalf("Get() returned error: %s", err)
+	}
+	if got != "s3cr3t" {
+		t.Errorf("Get() = %q, want %q", got, "s3cr3t")
+	}
+}
+
+func TestGetEmpty(t *testing.T) {
+	got, err := Get("", "")
+	if err != nil {
+		t.Fatalf("Get() returned error: %s", err)
+	}
+	if got != "" {
+		t.Errorf("Get() = %q, want empty string", got)
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("writing test file: %s", err)
+	}
+	got, err := Get("", path)
+	if err != nil {
+		t.Fatalf("Get() returned error: %s", err)
+	}
+	if got != "from-file" {
+		t.Errorf("Get() = %q, want %q", got, "from-file")
+	}
+}
+
+func TestGetMutuallyExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file"), 0600); err != nil {
+		t.Fatalf("writing test file: %s", err)
+	}
+	if _, err := Get("inline", path); err == nil {
+		t.Fatal("Get() with both inline and file set: expected error, got nil")
+	}
+}
+
+func TestGetFileMissing(t *testing.T) {
+	if _, err := Get("", filepath.Join(t.TempDir(), "does-not-exist")); err == nil {
+		t.Fatal("Get() with missing file: expected error, got nil")
+	}
+}
+
+// TestGetFileRotation mimics a mounted secret rotating between two probes:
+// Get must re-read the file each call rather than caching its first read.
+func TestGetFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("v1"), 0600); err != nil {
+		t.Fatalf("writing test file: %s", err)
+	}
+	got, err := Get("", path)
+	if err != nil {
+		t.Fatalf("Get() returned error: %s", err)
+	}
+	if got != "v1" {
+		t.Fatalf("Get() = %q, want %q", got, "v1")
+	}
+
+	if err := os.WriteFile(path, []byte("v2"), 0600); err != nil {
+		t.Fatalf("rewriting test file: %s", err)
+	}
+	got, err = Get("", path)
+	if err != nil {
+		t.Fatalf("Get() returned error: %s", err)
+	}
+	if got != "v2" {
+		t.Errorf("Get() after rotation = %q, want %q", got, "v2")
+	}
+}
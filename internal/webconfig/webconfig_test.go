@@ -0,0 +1,467 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// writeSelfSignedCert generates a self-signed CA and a leaf certificate
+// signed by it, writing both (plus the leaf's key) as PEM files under dir.
+// It returns the paths to the leaf cert, leaf key, and CA cert.
+func writeSelfSignedCert(t *testing.T, dir string) (certPath, keyPath, caPath string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %s", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating leaf key: %s", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caTemplate, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating leaf certificate: %s", err)
+	}
+
+	leafKeyDER, err := x509.MarshalECPrivateKey(leafKey)
+	if err != nil {
+		t.Fatalf("marshaling leaf key: %s", err)
+	}
+
+	certPath = filepath.Join(dir, "leaf.crt")
+	keyPath = filepath.Join(dir, "leaf.key")
+	caPath = filepath.Join(dir, "ca.crt")
+
+	writePEM(t, certPath, "CERTIFICATE", leafDER)
+	writePEM(t, keyPath, "EC PRIVATE KEY", leafKeyDER)
+	writePEM(t, caPath, "CERTIFICATE", caDER)
+
+	return certPath, keyPath, caPath
+}
+
+// generatedCA is a self-signed CA keypair, reused across generateLeaf calls
+// so multiple leaf certificates can be generated under the same CA.
+type generatedCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func generateCA(t *testing.T) generatedCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %s", err)
+	}
+	return generatedCA{key: key, cert: cert}
+}
+
+// generateLeaf creates a leaf certificate for 127.0.0.1 signed by ca, with
+// the given serial number so two leaves can be told apart after the fact.
+func generateLeaf(t *testing.T, ca generatedCA, serial int64) (certPEM, keyPEM []byte, leaf *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating leaf key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("creating leaf certificate: %s", err)
+	}
+	leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing leaf certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling leaf key: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		leaf
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating %s: %s", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+}
+
+func writeWebConfig(t *testing.T, dir, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, "web-config.yml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("writing web config: %s", err)
+	}
+	return path
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, caPath := writeSelfSignedCert(t, dir)
+	webConfigPath := writeWebConfig(t, dir, `
+tls_server_config:
+  cert_file: `+certPath+`
+  key_file: `+keyPath+`
+`)
+
+	h, err := NewHandler(webConfigPath)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() {
+		_ = h.Serve(server, ln)
+	}()
+	defer server.Close()
+
+	caPool := loadCertPool(t, caPath)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caPool}}}
+
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET over TLS: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestListenAndServeMTLSRejectsMissingClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, caPath := writeSelfSignedCert(t, dir)
+	webConfigPath := writeWebConfig(t, dir, `
+tls_server_config:
+  cert_file: `+certPath+`
+  key_file: `+keyPath+`
+  client_ca_file: `+caPath+`
+  client_auth_type: RequireAndVerifyClientCert
+`)
+
+	h, err := NewHandler(webConfigPath)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() {
+		_ = h.Serve(server, ln)
+	}()
+	defer server.Close()
+
+	caPool := loadCertPool(t, caPath)
+	// No client certificate presented: the handshake itself must fail.
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caPool}}}
+	if _, err := client.Get("https://" + ln.Addr().String() + "/"); err == nil {
+		t.Fatal("expected TLS handshake to fail without a client certificate, got nil error")
+	}
+}
+
+// TestListenAndServeTLSHotReloadsRotatedCert checks that rewriting
+// cert_file/key_file on disk and calling Reload takes effect on the very
+// next TLS handshake, without restarting the listener — the scenario
+// certificate() and Serve's GetCertificate callback exist to support.
+func TestListenAndServeTLSHotReloadsRotatedCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := generateCA(t)
+	certPath := filepath.Join(dir, "leaf.crt")
+	keyPath := filepath.Join(dir, "leaf.key")
+	caPath := filepath.Join(dir, "ca.crt")
+	writePEM(t, caPath, "CERTIFICATE", ca.cert.Raw)
+
+	cert1PEM, key1PEM, leaf1 := generateLeaf(t, ca, 2)
+	if err := os.WriteFile(certPath, cert1PEM, 0600); err != nil {
+		t.Fatalf("writing leaf cert: %s", err)
+	}
+	if err := os.WriteFile(keyPath, key1PEM, 0600); err != nil {
+		t.Fatalf("writing leaf key: %s", err)
+	}
+	webConfigPath := writeWebConfig(t, dir, `
+tls_server_config:
+  cert_file: `+certPath+`
+  key_file: `+keyPath+`
+`)
+
+	h, err := NewHandler(webConfigPath)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() {
+		_ = h.Serve(server, ln)
+	}()
+	defer server.Close()
+
+	caPool := loadCertPool(t, caPath)
+	getLeafSerial := func() *big.Int {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: caPool})
+		if err != nil {
+			t.Fatalf("dialing TLS: %s", err)
+		}
+		defer conn.Close()
+		certs := conn.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			t.Fatal("server presented no certificates")
+		}
+		return certs[0].SerialNumber
+	}
+
+	if got := getLeafSerial(); got.Cmp(leaf1.SerialNumber) != 0 {
+		t.Fatalf("before rotation, server presented serial %s, want %s", got, leaf1.SerialNumber)
+	}
+
+	cert2PEM, key2PEM, leaf2 := generateLeaf(t, ca, 3)
+	if err := os.WriteFile(certPath, cert2PEM, 0600); err != nil {
+		t.Fatalf("rotating leaf cert: %s", err)
+	}
+	if err := os.WriteFile(keyPath, key2PEM, 0600); err != nil {
+		t.Fatalf("rotating leaf key: %s", err)
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Reload() returned error: %s", err)
+	}
+
+	if got := getLeafSerial(); got.Cmp(leaf2.SerialNumber) != 0 {
+		t.Errorf("after rotation, server presented serial %s, want %s", got, leaf2.SerialNumber)
+	}
+}
+
+func loadCertPool(t *testing.T, caPath string) *x509.CertPool {
+	t.Helper()
+	data, err := os.ReadFile(caPath)
+	if err != nil {
+		t.Fatalf("reading CA file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatal("no certificates parsed from CA file")
+	}
+	return pool
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("generating bcrypt hash: %s", err)
+	}
+	dir := t.TempDir()
+	webConfigPath := writeWebConfig(t, dir, `
+basic_auth_users:
+  alice: `+string(hash)+`
+`)
+
+	h, err := NewHandler(webConfigPath)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %s", err)
+	}
+
+	handler := h.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name       string
+		user, pass string
+		setAuth    bool
+		wantStatus int
+	}{
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+		{"unknown user", "mallory", "whatever", true, http.StatusUnauthorized},
+		{"wrong password", "alice", "wrong", true, http.StatusUnauthorized},
+		{"correct credentials", "alice", "s3cr3t", true, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.setAuth {
+				req.SetBasicAuth(tc.user, tc.pass)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestBasicAuthNoUsersConfiguredIsNoOp(t *testing.T) {
+	dir := t.TempDir()
+	webConfigPath := writeWebConfig(t, dir, "")
+
+	h, err := NewHandler(webConfigPath)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %s", err)
+	}
+	handler := h.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d (no auth configured should pass through)", rr.Code, http.StatusOK)
+	}
+}
+
+func TestReloadUpdatesBasicAuthUsers(t *testing.T) {
+	hashV1, err := bcrypt.GenerateFromPassword([]byte("v1-password"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("generating bcrypt hash: %s", err)
+	}
+	dir := t.TempDir()
+	webConfigPath := writeWebConfig(t, dir, `
+basic_auth_users:
+  alice: `+string(hashV1)+`
+`)
+
+	h, err := NewHandler(webConfigPath)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %s", err)
+	}
+	handler := h.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	checkAuth := func(pass string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("alice", pass)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := checkAuth("v2-password"); code != http.StatusUnauthorized {
+		t.Fatalf("before rotation, new password accepted with status %d", code)
+	}
+
+	hashV2, err := bcrypt.GenerateFromPassword([]byte("v2-password"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("generating bcrypt hash: %s", err)
+	}
+	cfg := Config{BasicAuthUsers: map[string]string{"alice": string(hashV2)}}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshaling rotated config: %s", err)
+	}
+	if err := os.WriteFile(webConfigPath, out, 0600); err != nil {
+		t.Fatalf("rewriting web config: %s", err)
+	}
+
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Reload() returned error: %s", err)
+	}
+
+	if code := checkAuth("v1-password"); code != http.StatusUnauthorized {
+		t.Errorf("after rotation, old password accepted with status %d", code)
+	}
+	if code := checkAuth("v2-password"); code != http.StatusOK {
+		t.Errorf("after rotation, new password rejected with status %d", code)
+	}
+}
@@ -19,21 +19,28 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v2"
 
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"net"
 	"strings"
 
 	"github.com/gjflsl/blackbox_exporter/config"
+	"github.com/gjflsl/blackbox_exporter/internal/webconfig"
 	"github.com/gjflsl/blackbox_exporter/prober"
 )
 
@@ -41,11 +48,18 @@ var (
 	sc = &config.SafeConfig{
 		C: &config.Config{},
 	}
+	wc *webconfig.Handler
 
 	configFile        = kingpin.Flag("config.file", "Blackbox exporter configuration file.").Default("blackbox.yml").String()
 	listenAddress     = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9115").String()
 	timeoutOffset     = kingpin.Flag("timeout-offset", "Offset to subtract from timeout in seconds.").Default("0.5").Float64()
 	ipWhitelistString = kingpin.Flag("web.ip-whitelist", "Set the whitelist of IP. Example: \"127.0.0.1,172.17.2.1/24,1080:0:0:0:8:800:200C:417A/128\"").Default("0.0.0.0/0,::/0").String()
+	webConfigFile     = kingpin.Flag("web.config.file", "Path to a file with TLS and basic auth configuration for the exporter's web server.").Default("").String()
+	configAutoReload  = kingpin.Flag("config.auto-reload", "Automatically reload the config file on changes, in addition to SIGHUP and /-/reload.").Default("false").Bool()
+
+	classicProbeDuration      = kingpin.Flag("feature.classic-probe-duration", "Keep emitting probe_duration_seconds as a classic metric (gauge, or classic histogram buckets when native histograms are enabled).").Default("true").Bool()
+	nativeHistograms          = kingpin.Flag("feature.native-histograms", "Emit probe_duration_seconds as a native (sparse) histogram.").Default("false").Bool()
+	nativeHistogramMaxBuckets = kingpin.Flag("feature.native-histogram-max-buckets", "Maximum number of buckets used by native histograms.").Default("160").Uint32()
 
 	Probers = map[string]prober.ProbeFn{
 		"http": prober.ProbeHTTP,
@@ -55,15 +69,32 @@ var (
 	}
 )
 
+// probeHandler runs one or more modules (comma-separated in the "module"
+// query parameter) against the same target and serves the combined
+// metrics. With a single module the output is byte-identical to the
+// pre-batching behavior; with more than one, every metric gets an added
+// "module" label, and neither an error nor a panic in one module's prober
+// affects the others' probe_success — the panic is recovered per
+// sub-probe goroutine so it can only drop that module's metrics, not the
+// whole scrape.
 func probeHandler(w http.ResponseWriter, r *http.Request, c *config.Config, ipWhitelistString []*net.IPNet) {
-	moduleName := r.URL.Query().Get("module")
-	if moduleName == "" {
-		moduleName = "http_2xx"
+	moduleNames := strings.Split(r.URL.Query().Get("module"), ",")
+	if len(moduleNames) == 1 && moduleNames[0] == "" {
+		moduleNames = []string{"http_2xx"}
 	}
-	module, ok := c.Modules[moduleName]
-	if !ok {
-		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), 400)
-		return
+
+	modules := make([]config.Module, len(moduleNames))
+	var minTimeout float64
+	for i, name := range moduleNames {
+		module, ok := c.Modules[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown module %q", name), 400)
+			return
+		}
+		if s := module.Timeout.Seconds(); s > 0 && (minTimeout == 0 || s < minTimeout) {
+			minTimeout = s
+		}
+		modules[i] = module
 	}
 
 	// If a timeout is configured via the Prometheus header, add it to the request.
@@ -79,22 +110,13 @@ func probeHandler(w http.ResponseWriter, r *http.Request, c *config.Config, ipWh
 	if timeoutSeconds == 0 {
 		timeoutSeconds = 10
 	}
-
-	if module.Timeout.Seconds() < timeoutSeconds && module.Timeout.Seconds() > 0 {
-		timeoutSeconds = module.Timeout.Seconds()
+	if minTimeout > 0 && minTimeout < timeoutSeconds {
+		timeoutSeconds = minTimeout
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration((timeoutSeconds-*timeoutOffset)*1e9))
 	defer cancel()
 	r = r.WithContext(ctx)
 
-	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_success",
-		Help: "Displays whether or not the probe was a success",
-	})
-	probeDurationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_duration_seconds",
-		Help: "Returns how long the probe took to complete in seconds",
-	})
 	params := r.URL.Query()
 	target := params.Get("target")
 	configData := params.Get("config")
@@ -103,31 +125,163 @@ func probeHandler(w http.ResponseWriter, r *http.Request, c *config.Config, ipWh
 		return
 	}
 	if configData != "" {
-		recoverModule, err := config.RecoverConfig(configData, &module)
-		if err != nil {
-			http.Error(w, err.Error(), 400)
+		for i, module := range modules {
+			recoverModule, err := config.RecoverConfig(configData, &module)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			modules[i] = recoverModule
+		}
+	}
+	for _, module := range modules {
+		if _, ok := Probers[module.Prober]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown prober %q", module.Prober), 400)
 			return
 		}
-		module = recoverModule
 	}
 
-	prober, ok := Probers[module.Prober]
-	if !ok {
-		http.Error(w, fmt.Sprintf("Unknown prober %q", module.Prober), 400)
+	if len(modules) == 1 {
+		registry := prometheus.NewRegistry()
+		runProbe(ctx, target, modules[0], registry)
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{}, ipWhitelistString)
+		h.ServeHTTP(w, r)
 		return
 	}
 
-	start := time.Now()
-	registry := prometheus.NewRegistry()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		merged = map[string]*dto.MetricFamily{}
+	)
+	for i, module := range modules {
+		wg.Add(1)
+		go func(moduleName string, module config.Module) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("Recovered from panic probing module %q: %v", moduleName, r)
+				}
+			}()
+			registry := prometheus.NewRegistry()
+			runProbe(ctx, target, module, registry)
+			gathered, err := registry.Gather()
+			if err != nil {
+				log.Errorf("Error gathering metrics for module %q: %s", moduleName, err)
+				return
+			}
+			addModuleLabel(gathered, moduleName)
+			mu.Lock()
+			mergeMetricFamilies(merged, gathered)
+			mu.Unlock()
+		}(moduleNames[i], module)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	contentType := expfmt.Negotiate(r.Header)
+	w.Header().Set("Content-Type", string(contentType))
+	enc := expfmt.NewEncoder(w, contentType)
+	for _, name := range names {
+		if err := enc.Encode(merged[name]); err != nil {
+			log.Errorf("Error encoding metric family %q: %s", name, err)
+		}
+	}
+}
+
+// mergeMetricFamilies merges src into dst by metric name, appending each
+// family's Metric slice onto any family already present rather than adding
+// a second entry. Every module in a batched /probe scrape registers
+// probe_success and probe_duration_seconds under the same name, so without
+// this a multi-module response would contain two "# HELP probe_success"
+// blocks for the same metric — invalid exposition format that expfmt's own
+// parser rejects ("second HELP line for metric name ...").
+func mergeMetricFamilies(dst map[string]*dto.MetricFamily, src []*dto.MetricFamily) {
+	for _, mf := range src {
+		existing, ok := dst[mf.GetName()]
+		if !ok {
+			dst[mf.GetName()] = mf
+			continue
+		}
+		existing.Metric = append(existing.Metric, mf.Metric...)
+	}
+}
+
+// nativeHistogramBucketFactor is the growth factor between adjacent native
+// histogram buckets. 1.1 matches the value Prometheus itself uses for its
+// own native histograms.
+const nativeHistogramBucketFactor = 1.1
+
+// runProbe runs a single module's prober against target, registering
+// probe_success and probe_duration_seconds alongside anything the prober
+// itself registers. probe_duration_seconds is a classic gauge unless
+// --feature.native-histograms is set, in which case it becomes a native
+// histogram (optionally carrying classic buckets too, controlled by
+// --feature.classic-probe-duration) so latency can be aggregated across
+// targets with histogram_quantile. With both flags off, no
+// probe_duration_seconds metric is emitted at all.
+func runProbe(ctx context.Context, target string, module config.Module, registry *prometheus.Registry) {
+	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Displays whether or not the probe was a success",
+	})
 	registry.MustRegister(probeSuccessGauge)
-	registry.MustRegister(probeDurationGauge)
+
+	var (
+		probeDurationGauge     prometheus.Gauge
+		probeDurationHistogram prometheus.Histogram
+	)
+	if *nativeHistograms {
+		histogramOpts := prometheus.HistogramOpts{
+			Name:                           "probe_duration_seconds",
+			Help:                           "Returns how long the probe took to complete in seconds",
+			NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: *nativeHistogramMaxBuckets,
+		}
+		if *classicProbeDuration {
+			histogramOpts.Buckets = prometheus.DefBuckets
+		}
+		probeDurationHistogram = prometheus.NewHistogram(histogramOpts)
+		registry.MustRegister(probeDurationHistogram)
+	} else if *classicProbeDuration {
+		probeDurationGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Returns how long the probe took to complete in seconds",
+		})
+		registry.MustRegister(probeDurationGauge)
+	}
+
+	prober := Probers[module.Prober]
+	start := time.Now()
 	success := prober(ctx, target, module, registry)
-	probeDurationGauge.Set(time.Since(start).Seconds())
+	duration := time.Since(start).Seconds()
+	if probeDurationHistogram != nil {
+		probeDurationHistogram.Observe(duration)
+	} else if probeDurationGauge != nil {
+		probeDurationGauge.Set(duration)
+	}
 	if success {
 		probeSuccessGauge.Set(1)
 	}
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{}, ipWhitelistString)
-	h.ServeHTTP(w, r)
+}
+
+// addModuleLabel adds a "module" label to every metric in mfs, so that
+// metrics from a batched multi-module /probe scrape can be merged into one
+// response without clobbering each other.
+func addModuleLabel(mfs []*dto.MetricFamily, moduleName string) {
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String("module"),
+				Value: proto.String(moduleName),
+			})
+		}
+	}
 }
 
 func init() {
@@ -140,6 +294,10 @@ func main() {
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 
+	prober.NativeHistograms = *nativeHistograms
+	prober.ClassicProbeDuration = *classicProbeDuration
+	prober.NativeHistogramMaxBuckets = *nativeHistogramMaxBuckets
+
 	log.Infoln("Starting blackbox_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
@@ -148,6 +306,12 @@ func main() {
 	}
 	log.Infoln("Loaded config file")
 
+	var err error
+	wc, err = webconfig.NewHandler(*webConfigFile)
+	if err != nil {
+		log.Fatalf("Error loading web config file: %s", err)
+	}
+
 	var ipWhitelist []*net.IPNet
 	for _, netIpString := range strings.Split(*ipWhitelistString, ",") {
 		ipAdd := net.ParseIP(netIpString)
@@ -168,29 +332,27 @@ func main() {
 
 	hup := make(chan os.Signal)
 	reloadCh := make(chan chan error)
+	autoReloadCh := make(chan string)
 	signal.Notify(hup, syscall.SIGHUP)
+	if *configAutoReload {
+		if err := watchConfig(*configFile, autoReloadCh); err != nil {
+			log.Errorf("Error watching config file for auto-reload: %s", err)
+		}
+	}
 	go func() {
 		for {
 			select {
 			case <-hup:
-				if err := sc.ReloadConfig(*configFile); err != nil {
-					log.Errorf("Error reloading config: %s", err)
-					continue
-				}
-				log.Infoln("Loaded config file")
+				reload("SIGHUP")
 			case rc := <-reloadCh:
-				if err := sc.ReloadConfig(*configFile); err != nil {
-					log.Errorf("Error reloading config: %s", err)
-					rc <- err
-				} else {
-					log.Infoln("Loaded config file")
-					rc <- nil
-				}
+				rc <- reload("/-/reload")
+			case trigger := <-autoReloadCh:
+				reload(trigger)
 			}
 		}
 	}()
 
-	http.HandleFunc("/-/reload",
+	http.Handle("/-/reload", wc.BasicAuth(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			if promhttp.CheckInIpWhitelist(w, r, ipWhitelist) == false {
 				return
@@ -206,9 +368,9 @@ func main() {
 			if err := <-rc; err != nil {
 				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
 			}
-		})
-	http.Handle("/metrics", promhttp.Handler(ipWhitelist))
-	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		})))
+	http.Handle("/metrics", wc.BasicAuth(promhttp.Handler(ipWhitelist)))
+	http.Handle("/probe", wc.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if promhttp.CheckInIpWhitelist(w, r, ipWhitelist) == false {
 			return
 		}
@@ -216,8 +378,8 @@ func main() {
 		conf := sc.C
 		sc.Unlock()
 		probeHandler(w, r, conf, ipWhitelist)
-	})
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	})))
+	http.Handle("/", wc.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if promhttp.CheckInIpWhitelist(w, r, ipWhitelist) == false {
 			return
 		}
@@ -230,9 +392,9 @@ func main() {
     <p><a href="/config">Configuration</a></p>
     </body>
     </html>`))
-	})
+	})))
 
-	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+	http.Handle("/config", wc.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if promhttp.CheckInIpWhitelist(w, r, ipWhitelist) == false {
 			return
 		}
@@ -245,10 +407,11 @@ func main() {
 			return
 		}
 		w.Write(c)
-	})
+	})))
 
 	log.Infoln("Listening on", *listenAddress)
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+	server := &http.Server{Addr: *listenAddress}
+	if err := wc.ListenAndServe(server); err != nil {
 		log.Fatalf("Error starting HTTP server: %s", err)
 	}
 }
@@ -0,0 +1,118 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/gjflsl/blackbox_exporter/config"
+	"github.com/gjflsl/blackbox_exporter/prober"
+)
+
+func gauge(name string, value float64, labels ...string) *dto.MetricFamily {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Help:   proto.String("help for " + name),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{m},
+	}
+}
+
+// TestMergeMetricFamiliesDedupesByName guards against a batched multi-module
+// scrape producing two "# HELP"/"# TYPE" blocks for the same metric name,
+// which is invalid exposition format and gets rejected by expfmt's parser.
+func TestMergeMetricFamiliesDedupesByName(t *testing.T) {
+	dst := map[string]*dto.MetricFamily{}
+	mergeMetricFamilies(dst, []*dto.MetricFamily{gauge("probe_success", 1, "module", "http_2xx")})
+	mergeMetricFamilies(dst, []*dto.MetricFamily{gauge("probe_success", 0, "module", "tcp_connect")})
+
+	if len(dst) != 1 {
+		t.Fatalf("got %d distinct metric families, want 1", len(dst))
+	}
+	mf := dst["probe_success"]
+	if len(mf.Metric) != 2 {
+		t.Fatalf("got %d metrics under probe_success, want 2", len(mf.Metric))
+	}
+
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	if err := enc.Encode(mf); err != nil {
+		t.Fatalf("Encode() returned error: %s", err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "# HELP probe_success"); n != 1 {
+		t.Errorf("output has %d \"# HELP probe_success\" lines, want exactly 1:\n%s", n, out)
+	}
+	if n := strings.Count(out, "# TYPE probe_success"); n != 1 {
+		t.Errorf("output has %d \"# TYPE probe_success\" lines, want exactly 1:\n%s", n, out)
+	}
+}
+
+func TestMergeMetricFamiliesDistinctNames(t *testing.T) {
+	dst := map[string]*dto.MetricFamily{}
+	mergeMetricFamilies(dst, []*dto.MetricFamily{gauge("probe_success", 1)})
+	mergeMetricFamilies(dst, []*dto.MetricFamily{gauge("probe_duration_seconds", 0.5)})
+
+	if len(dst) != 2 {
+		t.Fatalf("got %d distinct metric families, want 2", len(dst))
+	}
+}
+
+// TestProbeHandlerMultiModulePanicIsolation calls probeHandler, the actual
+// HTTP entry point, with two modules where one's prober panics. It checks
+// both halves of the multi-module contract together: the other module's
+// probe_success must still come back in the combined response, and the
+// panicking module's metrics must be dropped entirely.
+func TestProbeHandlerMultiModulePanicIsolation(t *testing.T) {
+	origProbers := Probers
+	defer func() { Probers = origProbers }()
+	Probers = map[string]prober.ProbeFn{
+		"ok": func(ctx context.Context, target string, module config.Module, registry *prometheus.Registry) bool {
+			return true
+		},
+		"panics": func(ctx context.Context, target string, module config.Module, registry *prometheus.Registry) bool {
+			panic("boom")
+		},
+	}
+
+	c := &config.Config{Modules: map[string]config.Module{
+		"mod_ok":     {Prober: "ok"},
+		"mod_panics": {Prober: "panics"},
+	}}
+
+	req := httptest.NewRequest("GET", "/probe?target=example.com&module=mod_ok,mod_panics", nil)
+	w := httptest.NewRecorder()
+	probeHandler(w, req, c, nil)
+
+	body := w.Body.String()
+	if !strings.Contains(body, `probe_success{module="mod_ok"} 1`) {
+		t.Fatalf("response missing successful module's probe_success=1:\n%s", body)
+	}
+	if strings.Contains(body, `module="mod_panics"`) {
+		t.Errorf("response contains metrics for the panicking module, want it dropped entirely:\n%s", body)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prober implements the blackbox_exporter probers. Only the "http"
+// prober is present in this checkout; tcp/icmp/dns (and the ProbeFn type
+// main.go wires them up through) aren't implemented here.
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/gjflsl/blackbox_exporter/config"
+	"github.com/gjflsl/blackbox_exporter/internal/pathorcontent"
+)
+
+// These mirror main.go's --feature.native-histograms,
+// --feature.classic-probe-duration and --feature.native-histogram-max-buckets
+// flags. main assigns them once, right after kingpin.Parse(), so that this
+// package's probe_http_duration_seconds histogram honors the same flags as
+// runProbe's probe_duration_seconds.
+var (
+	NativeHistograms          bool
+	ClassicProbeDuration             = true
+	NativeHistogramMaxBuckets uint32 = 160
+)
+
+// nativeHistogramBucketFactor matches the value main.go uses for
+// probe_duration_seconds.
+const nativeHistogramBucketFactor = 1.1
+
+// probeHTTPDurationPhases are the httptrace phases probe_http_duration_seconds
+// is broken down by.
+var probeHTTPDurationPhases = []string{"resolve", "connect", "tls", "processing", "transfer"}
+
+// ProbeHTTP issues a GET against target using module's HTTP client config
+// and registers probe_http_status_code with the outcome. Every *_file
+// secret on the client config (basic auth password, bearer credentials,
+// client certificate) is re-read from disk on this call via
+// pathorcontent.Get, so a rotated credential takes effect on the very next
+// probe without restarting the exporter.
+func ProbeHTTP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry) bool {
+	client, err := newHTTPClient(module.HTTP.HTTPClientConfig)
+	if err != nil {
+		return false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	if err := setAuthHeaders(req, module.HTTP.HTTPClientConfig); err != nil {
+		return false
+	}
+
+	durationVec := newProbeHTTPDurationSecondsVec()
+	if durationVec != nil {
+		registry.MustRegister(durationVec)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), newHTTPPhaseTracer(durationVec)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	statusCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_http_status_code",
+		Help: "Response HTTP status code",
+	})
+	registry.MustRegister(statusCode)
+	statusCode.Set(float64(resp.StatusCode))
+
+	return resp.StatusCode > 0 && resp.StatusCode < 400
+}
+
+// newProbeHTTPDurationSecondsVec builds the probe_http_duration_seconds
+// histogram vector, shaped by the same NativeHistograms/ClassicProbeDuration
+// flags runProbe uses for probe_duration_seconds. It returns nil when both
+// flags are off, so ProbeHTTP registers (and observes) nothing at all rather
+// than a histogram no one asked for.
+func newProbeHTTPDurationSecondsVec() *prometheus.HistogramVec {
+	if !NativeHistograms && !ClassicProbeDuration {
+		return nil
+	}
+	opts := prometheus.HistogramOpts{
+		Name: "probe_http_duration_seconds",
+		Help: "Duration of each phase of the HTTP request broken down by phase",
+	}
+	if NativeHistograms {
+		opts.NativeHistogramBucketFactor = nativeHistogramBucketFactor
+		opts.NativeHistogramMaxBucketNumber = NativeHistogramMaxBuckets
+	}
+	if ClassicProbeDuration {
+		opts.Buckets = prometheus.DefBuckets
+	}
+	return prometheus.NewHistogramVec(opts, []string{"phase"})
+}
+
+// newHTTPPhaseTracer returns an httptrace.ClientTrace that observes the
+// wall-clock time spent in each of probeHTTPDurationPhases into vec.
+func newHTTPPhaseTracer(vec *prometheus.HistogramVec) *httptrace.ClientTrace {
+	var (
+		start, resolveStart, connectStart, tlsStart time.Time
+	)
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			start = time.Now()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			resolveStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !resolveStart.IsZero() {
+				vec.WithLabelValues("resolve").Observe(time.Since(resolveStart).Seconds())
+			}
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if !connectStart.IsZero() {
+				vec.WithLabelValues("connect").Observe(time.Since(connectStart).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			if !tlsStart.IsZero() {
+				vec.WithLabelValues("tls").Observe(time.Since(tlsStart).Seconds())
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			if !start.IsZero() {
+				vec.WithLabelValues("processing").Observe(time.Since(start).Seconds())
+				start = time.Now()
+			}
+		},
+		GotFirstResponseByte: func() {
+			if !start.IsZero() {
+				vec.WithLabelValues("transfer").Observe(time.Since(start).Seconds())
+			}
+		},
+	}
+}
+
+// setAuthHeaders sets basic-auth and/or bearer Authorization headers on req
+// from cfg, resolving any *_file secret via pathorcontent.Get so a rotated
+// credential is picked up on this call rather than one cached earlier.
+func setAuthHeaders(req *http.Request, cfg config.HTTPClientConfig) error {
+	if cfg.BasicAuth != nil {
+		password, err := pathorcontent.Get(cfg.BasicAuth.Password, cfg.BasicAuth.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("resolving basic auth password: %s", err)
+		}
+		req.SetBasicAuth(cfg.BasicAuth.Username, password)
+	}
+	if cfg.Authorization != nil {
+		creds, err := pathorcontent.Get(cfg.Authorization.Credentials, cfg.Authorization.CredentialsFile)
+		if err != nil {
+			return fmt.Errorf("resolving authorization credentials: %s", err)
+		}
+		authType := cfg.Authorization.Type
+		if authType == "" {
+			authType = "Bearer"
+		}
+		req.Header.Set("Authorization", authType+" "+creds)
+	}
+	return nil
+}
+
+// newHTTPClient builds an *http.Client honoring module's client TLS
+// certificate, if configured, resolved via pathorcontent.Get so a rotated
+// cert/key pair takes effect on the next probe, and trusting the CAs in
+// ca_file, if set, to verify the target's certificate.
+func newHTTPClient(cfg config.HTTPClientConfig) (*http.Client, error) {
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.TLSConfig.ServerName,
+		InsecureSkipVerify: cfg.TLSConfig.InsecureSkipVerify,
+	}
+	if cfg.TLSConfig.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(cfg.TLSConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", cfg.TLSConfig.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.TLSConfig.Cert != "" || cfg.TLSConfig.CertFile != "" {
+		certPEM, err := pathorcontent.Get(cfg.TLSConfig.Cert, cfg.TLSConfig.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %s", err)
+		}
+		keyPEM, err := pathorcontent.Get(cfg.TLSConfig.Key, cfg.TLSConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client key: %s", err)
+		}
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("parsing client certificate: %s", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}, nil
+}
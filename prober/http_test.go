@@ -0,0 +1,355 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/gjflsl/blackbox_exporter/config"
+)
+
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+	return path
+}
+
+// TestProbeHTTPBasicAuthRereadsPasswordFile checks that a password rotated
+// in PasswordFile between two ProbeHTTP calls is picked up on the very next
+// probe, without restarting anything in between.
+func TestProbeHTTPBasicAuthRereadsPasswordFile(t *testing.T) {
+	var gotPassword string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pass, _ := r.BasicAuth()
+		gotPassword = pass
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	passwordFile := writeFile(t, dir, "password", "v1")
+	module := config.Module{HTTP: config.HTTPProbe{HTTPClientConfig: config.HTTPClientConfig{
+		BasicAuth: &config.BasicAuth{Username: "alice", PasswordFile: passwordFile},
+	}}}
+
+	if ok := ProbeHTTP(context.Background(), server.URL, module, prometheus.NewRegistry()); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	if gotPassword != "v1" {
+		t.Fatalf("server saw password %q, want %q", gotPassword, "v1")
+	}
+
+	if err := os.WriteFile(passwordFile, []byte("v2"), 0600); err != nil {
+		t.Fatalf("rotating password file: %s", err)
+	}
+	if ok := ProbeHTTP(context.Background(), server.URL, module, prometheus.NewRegistry()); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	if gotPassword != "v2" {
+		t.Errorf("after rotation, server saw password %q, want %q", gotPassword, "v2")
+	}
+}
+
+// TestProbeHTTPAuthorizationRereadsCredentialsFile is the same check for
+// the bearer-style Authorization header.
+func TestProbeHTTPAuthorizationRereadsCredentialsFile(t *testing.T) {
+	var gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	credentialsFile := writeFile(t, dir, "token", "tok-v1")
+	module := config.Module{HTTP: config.HTTPProbe{HTTPClientConfig: config.HTTPClientConfig{
+		Authorization: &config.Authorization{CredentialsFile: credentialsFile},
+	}}}
+
+	if ok := ProbeHTTP(context.Background(), server.URL, module, prometheus.NewRegistry()); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	if want := "Bearer tok-v1"; gotHeader != want {
+		t.Fatalf("server saw Authorization %q, want %q", gotHeader, want)
+	}
+
+	if err := os.WriteFile(credentialsFile, []byte("tok-v2"), 0600); err != nil {
+		t.Fatalf("rotating credentials file: %s", err)
+	}
+	if ok := ProbeHTTP(context.Background(), server.URL, module, prometheus.NewRegistry()); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	if want := "Bearer tok-v2"; gotHeader != want {
+		t.Errorf("after rotation, server saw Authorization %q, want %q", gotHeader, want)
+	}
+}
+
+// generatedClientCert is a self-signed CA plus a leaf certificate it signed,
+// PEM-encoded and ready to write to disk or add to a cert pool.
+type generatedClientCert struct {
+	certPEM []byte
+	keyPEM  []byte
+	leaf    *x509.Certificate
+	caPool  *x509.CertPool
+}
+
+// generateClientCert creates a fresh CA and a leaf certificate signed by it,
+// each call producing a distinct keypair so two calls can stand in for
+// "before" and "after" a certificate rotation.
+func generateClientCert(t *testing.T) generatedClientCert {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %s", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating leaf key: %s", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caTemplate, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating leaf certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("parsing leaf certificate: %s", err)
+	}
+	leafKeyDER, err := x509.MarshalECPrivateKey(leafKey)
+	if err != nil {
+		t.Fatalf("marshaling leaf key: %s", err)
+	}
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	return generatedClientCert{
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: leafKeyDER}),
+		leaf:    leaf,
+		caPool:  caPool,
+	}
+}
+
+// newMTLSServer starts an httptest TLS server that requires and verifies a
+// client certificate signed by caPool, recording the presented cert's
+// serial number on each request.
+func newMTLSServer(t *testing.T, caPool *x509.CertPool, gotSerial *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			*gotSerial = r.TLS.PeerCertificates[0].SerialNumber.String()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		ClientCAs:  caPool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	return server
+}
+
+// TestNewProbeHTTPDurationSecondsVecClassic checks the default (no native
+// histograms) shape: one metric family, one metric per phase, each carrying
+// classic buckets.
+func TestNewProbeHTTPDurationSecondsVecClassic(t *testing.T) {
+	origNative, origClassic := NativeHistograms, ClassicProbeDuration
+	NativeHistograms, ClassicProbeDuration = false, true
+	defer func() { NativeHistograms, ClassicProbeDuration = origNative, origClassic }()
+
+	reg := prometheus.NewRegistry()
+	vec := newProbeHTTPDurationSecondsVec()
+	reg.MustRegister(vec)
+	for _, phase := range probeHTTPDurationPhases {
+		vec.WithLabelValues(phase).Observe(0.1)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %s", err)
+	}
+	if len(mfs) != 1 {
+		t.Fatalf("got %d metric families, want 1", len(mfs))
+	}
+	mf := mfs[0]
+	if mf.GetName() != "probe_http_duration_seconds" {
+		t.Fatalf("got name %q, want probe_http_duration_seconds", mf.GetName())
+	}
+	if len(mf.Metric) != len(probeHTTPDurationPhases) {
+		t.Fatalf("got %d metrics, want %d (one per phase)", len(mf.Metric), len(probeHTTPDurationPhases))
+	}
+	for _, m := range mf.Metric {
+		if m.Histogram == nil || len(m.Histogram.Bucket) == 0 {
+			t.Errorf("metric %v has no classic histogram buckets", m.Label)
+		}
+	}
+}
+
+// TestNewProbeHTTPDurationSecondsVecNative checks that enabling
+// --feature.native-histograms produces metrics with the native histogram
+// schema populated, which is what distinguishes a native histogram from a
+// classic one in the exposition proto.
+func TestNewProbeHTTPDurationSecondsVecNative(t *testing.T) {
+	origNative, origClassic := NativeHistograms, ClassicProbeDuration
+	NativeHistograms, ClassicProbeDuration = true, false
+	defer func() { NativeHistograms, ClassicProbeDuration = origNative, origClassic }()
+
+	reg := prometheus.NewRegistry()
+	vec := newProbeHTTPDurationSecondsVec()
+	reg.MustRegister(vec)
+	vec.WithLabelValues("connect").Observe(0.05)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %s", err)
+	}
+	if len(mfs) != 1 {
+		t.Fatalf("got %d metric families, want 1", len(mfs))
+	}
+	m := mfs[0].Metric[0]
+	if m.Histogram == nil || m.Histogram.Schema == nil {
+		t.Fatal("expected native histogram schema to be set when NativeHistograms is enabled")
+	}
+	if len(m.Histogram.Bucket) != 0 {
+		t.Errorf("expected no classic buckets when ClassicProbeDuration is disabled, got %d", len(m.Histogram.Bucket))
+	}
+}
+
+// TestNewProbeHTTPDurationSecondsVecDisabled checks that with both
+// NativeHistograms and ClassicProbeDuration off, ProbeHTTP registers (and
+// thus emits) no probe_http_duration_seconds metric at all, mirroring
+// runProbe's handling of probe_duration_seconds.
+func TestNewProbeHTTPDurationSecondsVecDisabled(t *testing.T) {
+	origNative, origClassic := NativeHistograms, ClassicProbeDuration
+	NativeHistograms, ClassicProbeDuration = false, false
+	defer func() { NativeHistograms, ClassicProbeDuration = origNative, origClassic }()
+
+	if vec := newProbeHTTPDurationSecondsVec(); vec != nil {
+		t.Fatalf("newProbeHTTPDurationSecondsVec() = %v, want nil when both flags are off", vec)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	if ok := ProbeHTTP(context.Background(), server.URL, config.Module{}, reg); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %s", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "probe_http_duration_seconds" {
+			t.Fatalf("probe_http_duration_seconds was registered with both flags off")
+		}
+	}
+}
+
+// TestProbeHTTPClientCertRereadsFileBetweenProbes checks that a client
+// certificate/key pair rotated on disk between two ProbeHTTP calls against
+// an mTLS server is picked up on the very next probe.
+func TestProbeHTTPClientCertRereadsFileBetweenProbes(t *testing.T) {
+	cert1 := generateClientCert(t)
+	cert2 := generateClientCert(t)
+
+	var gotSerial string
+	server := newMTLSServer(t, cert1.caPool, &gotSerial)
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := writeFile(t, dir, "client.crt", string(cert1.certPEM))
+	keyFile := writeFile(t, dir, "client.key", string(cert1.keyPEM))
+	module := config.Module{HTTP: config.HTTPProbe{HTTPClientConfig: config.HTTPClientConfig{
+		TLSConfig: config.TLSConfig{
+			CertFile: certFile,
+			KeyFile:  keyFile,
+			// The server's own certificate is self-signed for this test
+			// and unrelated to client-cert verification; skip server
+			// verification so the test exercises only the client cert path.
+			InsecureSkipVerify: true,
+		},
+	}}}
+
+	if ok := ProbeHTTP(context.Background(), server.URL, module, prometheus.NewRegistry()); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	if gotSerial != cert1.leaf.SerialNumber.String() {
+		t.Fatalf("server saw client cert serial %q, want %q", gotSerial, cert1.leaf.SerialNumber.String())
+	}
+
+	// Rotate the cert/key files, then point the mTLS server's trust at the
+	// second CA instead of the first (distinct CA per generateClientCert call).
+	server.TLS.ClientCAs = cert2.caPool
+	if err := os.WriteFile(certFile, cert2.certPEM, 0600); err != nil {
+		t.Fatalf("rotating client cert file: %s", err)
+	}
+	if err := os.WriteFile(keyFile, cert2.keyPEM, 0600); err != nil {
+		t.Fatalf("rotating client key file: %s", err)
+	}
+	if ok := ProbeHTTP(context.Background(), server.URL, module, prometheus.NewRegistry()); !ok {
+		t.Fatalf("ProbeHTTP() returned false, want true")
+	}
+	if gotSerial != cert2.leaf.SerialNumber.String() {
+		t.Errorf("after rotation, server saw client cert serial %q, want %q", gotSerial, cert2.leaf.SerialNumber.String())
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const configReloadDebounce = 200 * time.Millisecond
+
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "blackbox_exporter_config_last_reload_successful",
+		Help: "Blackbox exporter config loaded successfully.",
+	})
+	configReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackbox_exporter_config_reloads_total",
+		Help: "Total number of times the configuration was reloaded.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configReloadsTotal)
+}
+
+// reload re-runs sc.ReloadConfig and wc.Reload for the config file and web
+// config file respectively, logging trigger and recording the two
+// config-reload metrics.
+func reload(trigger string) error {
+	err := sc.ReloadConfig(*configFile)
+	if err == nil {
+		err = wc.Reload()
+	}
+	if err != nil {
+		configReloadsTotal.WithLabelValues("failure").Inc()
+		configLastReloadSuccessful.Set(0)
+		log.Errorf("Error reloading config (triggered by %s): %s", trigger, err)
+		return err
+	}
+	configReloadsTotal.WithLabelValues("success").Inc()
+	configLastReloadSuccessful.Set(1)
+	log.Infof("Loaded config file (triggered by %s)", trigger)
+	return nil
+}
+
+// watchConfig establishes an fsnotify watch on path and, once that succeeds,
+// services WRITE, CREATE, RENAME and REMOVE events on a background
+// goroutine, sending a trigger string to changed each time the file settles,
+// debounced by configReloadDebounce so that a burst of writes from an
+// editor's atomic save only triggers one reload. Since some editors replace
+// the file's inode on save (write-to-temp-then-rename-over-path), the kernel
+// reports that as a CHMOD+REMOVE pair on path rather than a RENAME or WRITE,
+// so the watch is re-added and the reload is triggered on RENAME or REMOVE
+// too. The watch itself is established before watchConfig returns, so a
+// caller (or test) that writes to path immediately afterwards is guaranteed
+// to be seen.
+func watchConfig(path string, changed chan<- string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating config file watcher: %s", err)
+	}
+	if err := watcher.Add(path); err != nil {
+		return fmt.Errorf("watching config file %s: %s", path, err)
+	}
+
+	go runConfigWatcher(watcher, changed)
+	return nil
+}
+
+func runConfigWatcher(watcher *fsnotify.Watcher, changed chan<- string) {
+	var debounce *time.Timer
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+				// The editor replaced the inode; re-add the watch so we
+				// keep seeing events for the new file at the same path.
+				_ = watcher.Add(event.Name)
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(configReloadDebounce, func() {
+				changed <- "fsnotify"
+			})
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Error watching config file: %s", err)
+		}
+	}
+}
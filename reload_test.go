@@ -0,0 +1,119 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const watchConfigTestTimeout = configReloadDebounce + 2*time.Second
+
+func waitForTrigger(t *testing.T, changed <-chan string) {
+	t.Helper()
+	select {
+	case <-changed:
+	case <-time.After(watchConfigTestTimeout):
+		t.Fatal("timed out waiting for watchConfig to trigger a reload")
+	}
+}
+
+func assertNoTrigger(t *testing.T, changed <-chan string) {
+	t.Helper()
+	select {
+	case trigger := <-changed:
+		t.Fatalf("got unexpected trigger %q", trigger)
+	case <-time.After(configReloadDebounce / 2):
+	}
+}
+
+// TestWatchConfigTriggersOnWrite covers the common case of an in-place
+// write to the watched path.
+func TestWatchConfigTriggersOnWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("v1"), 0600); err != nil {
+		t.Fatalf("writing config: %s", err)
+	}
+
+	changed := make(chan string, 1)
+	if err := watchConfig(path, changed); err != nil {
+		t.Fatalf("watchConfig() returned error: %s", err)
+	}
+
+	if err := os.WriteFile(path, []byte("v2"), 0600); err != nil {
+		t.Fatalf("rewriting config: %s", err)
+	}
+	waitForTrigger(t, changed)
+}
+
+// TestWatchConfigTriggersOnAtomicRename covers the editor "save" pattern of
+// writing to a temp file and renaming it over the watched path, which
+// replaces the inode fsnotify is watching. watchConfig must re-add the
+// watch on the RENAME/REMOVE event rather than silently going quiet.
+func TestWatchConfigTriggersOnAtomicRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte("v1"), 0600); err != nil {
+		t.Fatalf("writing config: %s", err)
+	}
+
+	changed := make(chan string, 1)
+	if err := watchConfig(path, changed); err != nil {
+		t.Fatalf("watchConfig() returned error: %s", err)
+	}
+
+	tmp := filepath.Join(dir, "config.yml.tmp")
+	if err := os.WriteFile(tmp, []byte("v2"), 0600); err != nil {
+		t.Fatalf("writing temp file: %s", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("renaming temp file over config: %s", err)
+	}
+	waitForTrigger(t, changed)
+
+	// The watch must still be live on the new inode: a second atomic
+	// rename should trigger again.
+	tmp2 := filepath.Join(dir, "config.yml.tmp2")
+	if err := os.WriteFile(tmp2, []byte("v3"), 0600); err != nil {
+		t.Fatalf("writing second temp file: %s", err)
+	}
+	if err := os.Rename(tmp2, path); err != nil {
+		t.Fatalf("renaming second temp file over config: %s", err)
+	}
+	waitForTrigger(t, changed)
+}
+
+// TestWatchConfigDebouncesBurstOfWrites checks that a rapid burst of writes
+// collapses into a single trigger, per configReloadDebounce.
+func TestWatchConfigDebouncesBurstOfWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("v1"), 0600); err != nil {
+		t.Fatalf("writing config: %s", err)
+	}
+
+	changed := make(chan string, 4)
+	if err := watchConfig(path, changed); err != nil {
+		t.Fatalf("watchConfig() returned error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(path, []byte{byte('a' + i)}, 0600); err != nil {
+			t.Fatalf("rewriting config: %s", err)
+		}
+	}
+	waitForTrigger(t, changed)
+	assertNoTrigger(t, changed)
+}